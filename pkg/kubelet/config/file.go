@@ -18,127 +18,376 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/validation"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubelet"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
 
-	"github.com/golang/glog"
+	"github.com/howeyc/fsnotify"
 )
 
+// watchDebounce is how long sourceFile waits after the last filesystem event
+// before re-scanning the path, so that a burst of events (e.g. an editor's
+// write-then-rename) collapses into a single scan.
+const watchDebounce = 100 * time.Millisecond
+
 type sourceFile struct {
-	path    string
-	updates chan<- interface{}
+	path       string
+	period     time.Duration
+	updates    chan<- interface{}
+	log        logger
+	quarantine quarantineConfig
+	// pods is the set of pods observed on the last successful scan, keyed as
+	// docPodMap keys them (by the path of the file each pod was read from),
+	// so that the next scan can be diffed against it to emit incremental
+	// updates.
+	pods map[string]api.Pod
 }
 
 func NewSourceFile(path string, period time.Duration, updates chan<- interface{}) {
 	config := &sourceFile{
-		path:    path,
-		updates: updates,
+		path:       path,
+		period:     period,
+		updates:    updates,
+		log:        glogLogger{},
+		quarantine: defaultQuarantine,
+	}
+	config.log.Info("Watching path", "path", path)
+	go config.runForever()
+}
+
+// runForever does an initial scan and then watches the path for changes,
+// falling back to periodic polling if the watcher can't be established or
+// fails.
+func (s *sourceFile) runForever() {
+	if err := s.extractFromPath(); err != nil {
+		s.log.Error(err, "Unable to read config path", "path", s.path)
+	}
+
+	watcher, err := s.newWatcher()
+	if err != nil {
+		s.log.Error(err, "Unable to watch config path, falling back to polling", "path", s.path, "period", s.period)
+		util.Forever(s.run, s.period)
+		return
+	}
+
+	// util.Forever never returns, so a deferred Close would never run once we
+	// fall back to polling below; close the watcher explicitly right before
+	// each fallback instead, or this fsnotify instance and its reader
+	// goroutine leak for the life of the process.
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Event:
+			if !ok {
+				s.log.Info("Watcher for config path closed, falling back to polling", "path", s.path, "period", s.period)
+				watcher.Close()
+				util.Forever(s.run, s.period)
+				return
+			}
+			s.log.Debug("Watch event for config path", "path", s.path, "event", event)
+			debounce = time.After(watchDebounce)
+
+		case err, ok := <-watcher.Error:
+			if !ok {
+				s.log.Info("Watcher for config path closed, falling back to polling", "path", s.path, "period", s.period)
+				watcher.Close()
+				util.Forever(s.run, s.period)
+				return
+			}
+			s.log.Error(err, "Watcher error for config path, falling back to polling", "path", s.path, "period", s.period)
+			watcher.Close()
+			util.Forever(s.run, s.period)
+			return
+
+		case <-debounce:
+			debounce = nil
+			s.run()
+		}
+	}
+}
+
+// newWatcher establishes a watch on path, or on its parent directory instead
+// when path is a regular file, so that the file's removal and recreation (as
+// happens with atomic-rename writers) are observed too.
+func (s *sourceFile) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	watchPath := s.path
+	if statInfo, err := os.Stat(s.path); err == nil && statInfo.Mode().IsRegular() {
+		watchPath = filepath.Dir(s.path)
 	}
-	glog.V(1).Infof("Watching path %q", path)
-	go util.Forever(config.run, period)
+
+	if err := watcher.Watch(watchPath); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
 }
 
 func (s *sourceFile) run() {
 	if err := s.extractFromPath(); err != nil {
-		glog.Errorf("Unable to read config path %q: %v", s.path, err)
+		s.log.Error(err, "Unable to read config path", "path", s.path)
 	}
 }
 
-func (s *sourceFile) extractFromPath() error {
+func (s *sourceFile) extractFromPath() (err error) {
+	fileSourceScansTotal.Inc()
+	start := time.Now()
+	defer func() {
+		fileSourceScanDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			fileSourceScanErrorsTotal.WithLabelValues(scanErrorReason(err)).Inc()
+		}
+	}()
+
 	path := s.path
-	statInfo, err := os.Stat(path)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
+	statInfo, statErr := os.Stat(path)
+	if statErr != nil {
+		if !os.IsNotExist(statErr) {
+			return statErr
 		}
 		// Emit an update with an empty PodList to allow FileSource to be marked as seen
+		s.pods = nil
+		fileSourceLastPodCount.WithLabelValues(path).Set(0)
 		s.updates <- kubelet.PodUpdate{[]api.Pod{}, kubelet.SET, kubelet.FileSource}
-		return fmt.Errorf("path does not exist, ignoring")
+		return errPathNotExist
 	}
 
 	switch {
 	case statInfo.Mode().IsDir():
-		pods, err := extractFromDir(path)
-		if err != nil {
-			return err
+		pods, extractErr := extractFromDir(s.log, s.quarantine, path)
+		if extractErr != nil {
+			return extractErr
 		}
-		s.updates <- kubelet.PodUpdate{pods, kubelet.SET, kubelet.FileSource}
+		s.updatePods(pods)
 
 	case statInfo.Mode().IsRegular():
-		pod, err := extractFromFile(path)
-		if err != nil {
-			return err
+		// extractFromFile may return both a non-nil pods slice and an error
+		// (e.g. when only some documents in the file failed validation), so
+		// update from whatever it did manage to extract before propagating
+		// the error.
+		pods, extractErr := extractFromFile(s.log, s.quarantine, path)
+		s.updatePods(docPodMap(path, pods))
+		if extractErr != nil {
+			return extractErr
 		}
-		s.updates <- kubelet.PodUpdate{[]api.Pod{pod}, kubelet.SET, kubelet.FileSource}
 
 	default:
 		return fmt.Errorf("path is not a directory or file")
 	}
 
+	fileSourceLastPodCount.WithLabelValues(path).Set(float64(len(s.pods)))
 	return nil
 }
 
-// Get as many pod configs as we can from a directory.  Return an error iff something
-// prevented us from reading anything at all.  Do not return an error if only some files
-// were problematic.
-func extractFromDir(name string) ([]api.Pod, error) {
+// errPathNotExist is returned by extractFromPath when the configured path is
+// absent, after it has sent an empty SET update; scanErrorReason counts it
+// under the "not_found" reason.
+var errPathNotExist = fmt.Errorf("path does not exist, ignoring")
+
+// scanErrorReason buckets an extractFromPath error for the
+// file_source_scan_errors_total{reason=} metric.
+func scanErrorReason(err error) string {
+	switch {
+	case err == errPathNotExist:
+		return "not_found"
+	case os.IsPermission(err):
+		return "permission_denied"
+	default:
+		return "other"
+	}
+}
+
+// updatePods diffs the freshly scanned pods (keyed as docPodMap keys them)
+// against the previously observed set and emits one PodUpdate per added,
+// updated, or removed pod, rather than a full SET snapshot.
+func (s *sourceFile) updatePods(pods map[string]api.Pod) {
+	for path, pod := range pods {
+		old, ok := s.pods[path]
+		if !ok {
+			s.updates <- kubelet.PodUpdate{[]api.Pod{pod}, kubelet.ADD, kubelet.FileSource}
+		} else if !reflect.DeepEqual(old, pod) {
+			s.updates <- kubelet.PodUpdate{[]api.Pod{pod}, kubelet.UPDATE, kubelet.FileSource}
+		}
+	}
+	for path, pod := range s.pods {
+		if _, ok := pods[path]; !ok {
+			s.updates <- kubelet.PodUpdate{[]api.Pod{pod}, kubelet.REMOVE, kubelet.FileSource}
+		}
+	}
+	s.pods = pods
+}
+
+// Get as many pod configs as we can from a directory, keyed by docPodMap
+// (the path each was read from, plus a position for files yielding several
+// pods).  Return an error iff something prevented us from reading
+// anything at all.  Do not return an error if only some files were
+// problematic.
+func extractFromDir(log logger, quarantine quarantineConfig, name string) (map[string]api.Pod, error) {
 	dirents, err := filepath.Glob(filepath.Join(name, "[^.]*"))
 	if err != nil {
 		return nil, fmt.Errorf("glob failed: %v", err)
 	}
 
-	pods := make([]api.Pod, 0)
+	pods := make(map[string]api.Pod)
 	if len(dirents) == 0 {
 		return pods, nil
 	}
 
 	sort.Strings(dirents)
 	for _, path := range dirents {
+		if quarantine.isArtifact(path) {
+			// Skip a file's own quarantined/.error sibling so a manifest
+			// that fails validation is rejected once, not re-validated and
+			// re-quarantined (with a growing suffix) on every scan.
+			log.Debug("Skipping quarantine artifact", "path", path)
+			continue
+		}
+
 		statInfo, err := os.Stat(path)
 		if err != nil {
-			glog.V(1).Infof("Can't get metadata for %q: %v", path, err)
+			log.Error(err, "Can't get metadata for config path", "path", path)
 			continue
 		}
 
 		switch {
 		case statInfo.Mode().IsDir():
-			glog.V(1).Infof("Not recursing into config path %q", path)
+			log.Info("Not recursing into config path", "path", path)
 		case statInfo.Mode().IsRegular():
-			pod, err := extractFromFile(path)
+			filePods, err := extractFromFile(log, quarantine, path)
+			for key, pod := range docPodMap(path, filePods) {
+				pods[key] = pod
+			}
 			if err != nil {
-				glog.V(1).Infof("Can't process config file %q: %v", path, err)
-			} else {
-				pods = append(pods, pod)
+				log.Error(err, "Can't process config file", "path", path)
+				fileSourceParseErrorsTotal.WithLabelValues(path).Inc()
 			}
 		default:
-			glog.V(1).Infof("Config path %q is not a directory or file: %v", path, statInfo.Mode())
+			log.Info("Config path is not a directory or file", "path", path, "mode", statInfo.Mode())
 		}
 	}
 	return pods, nil
 }
 
-func extractFromFile(filename string) (pod api.Pod, err error) {
-	glog.V(3).Infof("Reading config file %q", filename)
+// docPodMap keys each pod read from path by path alone when pods holds a
+// single pod, or by path plus the pod's position in pods when it holds
+// several, so that every pod gets a unique identity to diff against.  pods
+// holds only the documents that passed validation, so a position is not the
+// document's index in the file once an earlier document has been rejected.
+func docPodMap(path string, pods []api.Pod) map[string]api.Pod {
+	keyed := make(map[string]api.Pod, len(pods))
+	for i, pod := range pods {
+		key := path
+		if len(pods) > 1 {
+			key = fmt.Sprintf("%s[%d]", path, i)
+		}
+		keyed[key] = pod
+	}
+	return keyed
+}
+
+// yamlDocumentSeparator matches a line containing only "---", the YAML
+// document separator.
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// extractFromFile parses every pod or manifest document in filename and
+// validates each against the API schema.  A file may hold a single
+// pod/manifest, several YAML documents separated by a "---" line, or a
+// top-level JSON array / PodList bundling several.  Each pod's default
+// name/namespace is derived from filename plus the document's index (or
+// from filename alone for a single-document file), so pods from the same
+// file get stable, unique identities.
+//
+// If any document would be rejected by the apiserver, that is recorded
+// rather than being silently dropped with only a log line, and a non-nil
+// error is returned alongside whatever pods from the file did pass
+// validation, so the caller can still surface those. A file with no valid
+// documents at all is quarantined (renamed to a sibling
+// quarantine.rejectedSuffix path, with the validation messages written to a
+// companion quarantine.errorSuffix file); a file with a mix of valid and
+// invalid documents is left in place, with the validation messages written
+// to a quarantine.errorSuffix file alongside it, so the valid documents'
+// identity and on-disk presence survive for the next scan.
+func extractFromFile(log logger, quarantine quarantineConfig, filename string) ([]api.Pod, error) {
+	log.Debug("Reading config file", "path", filename)
 	file, err := os.Open(filename)
 	if err != nil {
-		return pod, err
+		return nil, err
 	}
 	defer file.Close()
 
 	data, err := ioutil.ReadAll(file)
 	if err != nil {
-		return pod, err
+		return nil, err
+	}
+
+	docs, err := splitDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]api.Pod, 0, len(docs))
+	var validationMsgs []string
+	for i, doc := range docs {
+		name := filename
+		if len(docs) > 1 {
+			name = fmt.Sprintf("%s[%d]", filename, i)
+		}
+		pod, err := decodeDocument(doc, name)
+		if err != nil {
+			return nil, err
+		}
+		if errs := validation.ValidatePod(&pod); len(errs) > 0 {
+			for _, validationErr := range errs {
+				validationMsgs = append(validationMsgs, fmt.Sprintf("%s: %v", name, validationErr))
+			}
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	if len(validationMsgs) > 0 {
+		if len(pods) == 0 {
+			// Nothing in the file is usable, so move it out of the way
+			// entirely.
+			if err := quarantine.reject(filename, validationMsgs); err != nil {
+				log.Error(err, "Unable to quarantine invalid config file", "path", filename)
+			}
+		} else {
+			// Some documents are still valid and are keyed by filename; if
+			// we renamed filename out from under them, they would vanish on
+			// the next scan and get spuriously removed. Leave the file in
+			// place and just record the errors for operators to find.
+			if err := quarantine.recordErrors(filename, validationMsgs); err != nil {
+				log.Error(err, "Unable to record validation errors for config file", "path", filename)
+			}
+		}
+		return pods, fmt.Errorf("%s: rejected by validation: %s", filename, strings.Join(validationMsgs, "; "))
 	}
 
-	parsed, _, pod, manifestErr := tryDecodeSingleManifest(data, filename, true)
+	return pods, nil
+}
+
+// decodeDocument decodes a single pod or manifest document, named for error
+// messages and default identity by name.
+func decodeDocument(data []byte, name string) (pod api.Pod, err error) {
+	parsed, _, pod, manifestErr := tryDecodeSingleManifest(data, name, true)
 	if parsed {
 		if manifestErr != nil {
 			// It parsed but could not be used.
@@ -147,7 +396,7 @@ func extractFromFile(filename string) (pod api.Pod, err error) {
 		return pod, nil
 	}
 
-	parsed, pod, podErr := tryDecodeSinglePod(data, filename, true)
+	parsed, pod, podErr := tryDecodeSinglePod(data, name, true)
 	if parsed {
 		if podErr != nil {
 			return pod, podErr
@@ -157,5 +406,96 @@ func extractFromFile(filename string) (pod api.Pod, err error) {
 
 	return pod, fmt.Errorf("%v: read '%v', but couldn't parse as neither "+
 		"manifest (%v) nor pod (%v).\n",
-		filename, string(data), manifestErr, podErr)
+		name, string(data), manifestErr, podErr)
+}
+
+// quarantineConfig controls where extractFromFile moves manifest files whose
+// pods fail API validation.
+type quarantineConfig struct {
+	// rejectedSuffix is appended to a rejected file's path to build the path
+	// it is renamed to, e.g. ".rejected".
+	rejectedSuffix string
+	// errorSuffix is appended to the rejected path (by reject) or to the
+	// original path (by recordErrors) to build the path of the companion
+	// file holding the validation messages, e.g. ".error".
+	errorSuffix string
+}
+
+var defaultQuarantine = quarantineConfig{rejectedSuffix: ".rejected", errorSuffix: ".error"}
+
+// isArtifact reports whether path is itself a file previously produced by
+// reject or recordErrors, so scanners can skip re-validating (and
+// re-quarantining) it.
+func (q quarantineConfig) isArtifact(path string) bool {
+	return strings.HasSuffix(path, q.rejectedSuffix) ||
+		strings.HasSuffix(path, q.rejectedSuffix+q.errorSuffix) ||
+		strings.HasSuffix(path, q.errorSuffix)
+}
+
+// reject moves filename to its quarantined path and writes msgs to the
+// companion error file. It is only safe to call when none of filename's
+// documents passed validation; otherwise the valid ones' identity (keyed by
+// filename) would disappear from disk out from under them. Use recordErrors
+// for a file with a mix of valid and invalid documents.
+func (q quarantineConfig) reject(filename string, msgs []string) error {
+	rejectedPath := filename + q.rejectedSuffix
+	if err := os.Rename(filename, rejectedPath); err != nil {
+		return err
+	}
+	errorPath := rejectedPath + q.errorSuffix
+	return ioutil.WriteFile(errorPath, []byte(strings.Join(msgs, "\n")+"\n"), 0644)
+}
+
+// recordErrors writes msgs to filename's companion error file without moving
+// filename itself, so that documents which did pass validation stay at their
+// original path and keep being picked up by future scans.
+func (q quarantineConfig) recordErrors(filename string, msgs []string) error {
+	errorPath := filename + q.errorSuffix
+	return ioutil.WriteFile(errorPath, []byte(strings.Join(msgs, "\n")+"\n"), 0644)
+}
+
+// splitDocuments breaks a manifest file into its individual pod/manifest
+// documents.  It recognizes a top-level JSON array, a PodList's "items", and
+// YAML's "---" document separator; a file matching none of these is treated
+// as a single document.
+func splitDocuments(data []byte) ([][]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		docs := make([][]byte, len(items))
+		for i, item := range items {
+			docs[i] = []byte(item)
+		}
+		return docs, nil
+	}
+
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(trimmed, &list); err == nil && list.Items != nil {
+			docs := make([][]byte, len(list.Items))
+			for i, item := range list.Items {
+				docs[i] = []byte(item)
+			}
+			return docs, nil
+		}
+	}
+
+	var docs [][]byte
+	for _, raw := range yamlDocumentSeparator.Split(string(data), -1) {
+		doc := bytes.TrimSpace([]byte(raw))
+		if len(doc) == 0 {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		docs = [][]byte{trimmed}
+	}
+	return docs, nil
 }
@@ -0,0 +1,307 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubelet"
+)
+
+func manifestJSON(id string) []byte {
+	return []byte(`{
+		"version": "v1beta1",
+		"id": "` + id + `",
+		"containers": [{"name": "c", "image": "busybox"}]
+	}`)
+}
+
+// invalidManifestJSON parses but has no containers, which ValidatePod rejects.
+func invalidManifestJSON(id string) []byte {
+	return []byte(`{
+		"version": "v1beta1",
+		"id": "` + id + `",
+		"containers": []
+	}`)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("unable to write %q: %v", path, err)
+	}
+}
+
+func newTestSourceFile(dir string, updates chan interface{}) *sourceFile {
+	return &sourceFile{path: dir, updates: updates, log: glogLogger{}, quarantine: defaultQuarantine}
+}
+
+func expectUpdate(t *testing.T, updates chan interface{}, op kubelet.PodOperation) kubelet.PodUpdate {
+	select {
+	case got := <-updates:
+		update, ok := got.(kubelet.PodUpdate)
+		if !ok {
+			t.Fatalf("unexpected update type: %#v", got)
+		}
+		if update.Op != op {
+			t.Fatalf("expected op %v, got %v", op, update.Op)
+		}
+		return update
+	default:
+		t.Fatalf("expected a PodUpdate with op %v, got none", op)
+		return kubelet.PodUpdate{}
+	}
+}
+
+func TestExtractFromDirAddsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-add")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	writeFile(t, filepath.Join(dir, "pod1.json"), manifestJSON("pod1"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.ADD)
+}
+
+func TestExtractFromDirModifiesFileInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-modify")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	path := filepath.Join(dir, "pod1.json")
+	writeFile(t, path, manifestJSON("pod1"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.ADD)
+
+	// Re-scanning the unchanged file should not emit another update.
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	select {
+	case got := <-updates:
+		t.Fatalf("expected no update for an unchanged file, got %#v", got)
+	default:
+	}
+
+	writeFile(t, path, manifestJSON("pod1-changed"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.UPDATE)
+}
+
+func TestExtractFromDirRenamesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-rename")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	oldPath := filepath.Join(dir, "pod1.json")
+	newPath := filepath.Join(dir, "pod1-renamed.json")
+	writeFile(t, oldPath, manifestJSON("pod1"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.ADD)
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatalf("unable to rename file: %v", err)
+	}
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+
+	ops := map[kubelet.PodOperation]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-updates:
+			update, ok := got.(kubelet.PodUpdate)
+			if !ok {
+				t.Fatalf("unexpected update type: %#v", got)
+			}
+			ops[update.Op] = true
+		default:
+			t.Fatalf("expected two queued updates after rename, got %d", i)
+		}
+	}
+	if !ops[kubelet.ADD] || !ops[kubelet.REMOVE] {
+		t.Fatalf("expected both an ADD and a REMOVE after rename, got %v", ops)
+	}
+}
+
+func TestExtractFromDirDeletesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-delete")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	path := filepath.Join(dir, "pod1.json")
+	writeFile(t, path, manifestJSON("pod1"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.ADD)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("unable to remove file: %v", err)
+	}
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	expectUpdate(t, updates, kubelet.REMOVE)
+}
+
+func TestExtractFromDirQuarantinesInvalidFileAndKeepsValidOnes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-quarantine")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	goodPath := filepath.Join(dir, "good.json")
+	badPath := filepath.Join(dir, "bad.json")
+	writeFile(t, goodPath, manifestJSON("good"))
+	writeFile(t, badPath, invalidManifestJSON("bad"))
+
+	// extractFromDir does not fail the whole scan over one bad file (see its
+	// doc comment), so extractFromPath should report no error...
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("unexpected error from a directory containing a file that fails validation: %v", err)
+	}
+	// ...but the valid file's pod must still have been emitted.
+	expectUpdate(t, updates, kubelet.ADD)
+
+	rejectedPath := badPath + defaultQuarantine.rejectedSuffix
+	if _, err := os.Stat(rejectedPath); err != nil {
+		t.Fatalf("expected invalid file to be renamed to %q: %v", rejectedPath, err)
+	}
+	errorPath := rejectedPath + defaultQuarantine.errorSuffix
+	if contents, err := ioutil.ReadFile(errorPath); err != nil || len(contents) == 0 {
+		t.Fatalf("expected a non-empty companion error file at %q: %v", errorPath, err)
+	}
+
+	// A second scan must not re-validate (and so not re-quarantine) the
+	// already-rejected file or its companion error file.
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("unexpected error on re-scan after quarantine: %v", err)
+	}
+	select {
+	case got := <-updates:
+		t.Fatalf("expected no further updates once the bad file is quarantined, got %#v", got)
+	default:
+	}
+	if _, err := os.Stat(rejectedPath + defaultQuarantine.rejectedSuffix); err == nil {
+		t.Fatalf("quarantined file was re-quarantined with a doubled suffix")
+	}
+}
+
+func TestExtractFromFileReturnsValidPodsFromPartiallyRejectedDoc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-partial")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bundle.json")
+	data := append(append(manifestJSON("good"), []byte("\n---\n")...), invalidManifestJSON("bad")...)
+	writeFile(t, path, data)
+
+	pods, err := extractFromFile(glogLogger{}, defaultQuarantine, path)
+	if err == nil {
+		t.Fatalf("expected an error since one document in the bundle fails validation")
+	}
+	if len(pods) != 1 {
+		t.Fatalf("expected the valid document's pod to still be returned, got %d pods", len(pods))
+	}
+
+	// The valid document must not have been renamed away with the rest of
+	// the file: it's keyed by path, so losing the file would make its pod
+	// vanish from the next scan and get spuriously removed.
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected %q to remain in place after a partial rejection: %v", path, err)
+	}
+	if _, err := os.Stat(path + defaultQuarantine.rejectedSuffix); err == nil {
+		t.Fatalf("file with some valid documents should not be quarantined")
+	}
+	errorPath := path + defaultQuarantine.errorSuffix
+	if contents, err := ioutil.ReadFile(errorPath); err != nil || len(contents) == 0 {
+		t.Fatalf("expected a non-empty companion error file at %q: %v", errorPath, err)
+	}
+}
+
+// TestSourceFilePersistsValidPodsFromPartiallyRejectedFile exercises the
+// single-file sourceFile.path mode (not a directory) across two scans, which
+// is what actually catches the file disappearing out from under its valid
+// pod: a single bad scan can still emit the right ADD, but only a second
+// scan proves the pod wasn't spuriously removed afterward.
+func TestSourceFilePersistsValidPodsFromPartiallyRejectedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-partial-persist")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bundle.json")
+	data := append(append(manifestJSON("good"), []byte("\n---\n")...), invalidManifestJSON("bad")...)
+	writeFile(t, path, data)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(path, updates)
+
+	if err := source.extractFromPath(); err == nil {
+		t.Fatalf("expected an error since one document in the bundle fails validation")
+	}
+	expectUpdate(t, updates, kubelet.ADD)
+
+	// A second scan of the same (still-present) file must not remove the
+	// pod that already passed validation.
+	if err := source.extractFromPath(); err == nil {
+		t.Fatalf("expected the second scan to still report the rejected document")
+	}
+	select {
+	case got := <-updates:
+		t.Fatalf("expected no further updates for the still-valid pod, got %#v", got)
+	default:
+	}
+}
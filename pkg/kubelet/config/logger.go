@@ -0,0 +1,62 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// logger is the structured key/value logging sink used by sourceFile.  It is
+// intentionally tiny so that a klog or slog backend can implement it without
+// pulling either dependency into this package.  NewSourceFile always uses
+// glogLogger; no exported function accepts another logger.
+// Info and Debug keep the same startup/lifecycle vs. per-scan-chatter split
+// the old ad-hoc V(1)/V(3) glog calls had, so operators running at the
+// previous default verbosity don't lose visibility into source startup.
+type logger interface {
+	Info(msg string, keysAndValues ...interface{})
+	Debug(msg string, keysAndValues ...interface{})
+	Error(err error, msg string, keysAndValues ...interface{})
+}
+
+// glogLogger is the default logger, which renders key/value pairs onto a
+// glog line rather than adopting a JSON or logfmt encoding outright.
+type glogLogger struct{}
+
+func (glogLogger) Info(msg string, keysAndValues ...interface{}) {
+	glog.V(1).Info(formatLog(msg, keysAndValues))
+}
+
+func (glogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	glog.V(3).Info(formatLog(msg, keysAndValues))
+}
+
+func (glogLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	glog.Error(formatLog(msg, append(keysAndValues, "err", err)))
+}
+
+func formatLog(msg string, keysAndValues []interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&buf, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return buf.String()
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	fileSourceScansTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "file_source",
+		Name:      "scans_total",
+		Help:      "Total number of file config source path scans.",
+	})
+
+	fileSourceScanErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "file_source",
+		Name:      "scan_errors_total",
+		Help:      "Total number of file config source path scans that failed, by reason.",
+	}, []string{"reason"})
+
+	fileSourceParseErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "file_source",
+		Name:      "parse_errors_total",
+		Help:      "Total number of manifest files in a scanned directory that failed to read, parse or validate, by path.",
+	}, []string{"path"})
+
+	fileSourceScanDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Subsystem: "file_source",
+		Name:      "scan_duration_seconds",
+		Help:      "Time taken scanning the file config source path.",
+	})
+
+	// fileSourceLastPodCount is a gauge rather than a counter because
+	// operators care about the current count, e.g. to alert when a
+	// static-pod directory unexpectedly empties.
+	fileSourceLastPodCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "file_source",
+		Name:      "last_pod_count",
+		Help:      "Number of pods observed on the last successful scan, by path.",
+	}, []string{"path"})
+)
+
+func init() {
+	prometheus.MustRegister(fileSourceScansTotal)
+	prometheus.MustRegister(fileSourceScanErrorsTotal)
+	prometheus.MustRegister(fileSourceParseErrorsTotal)
+	prometheus.MustRegister(fileSourceScanDuration)
+	prometheus.MustRegister(fileSourceLastPodCount)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("unable to read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, path string) float64 {
+	var m dto.Metric
+	if err := fileSourceLastPodCount.WithLabelValues(path).Write(&m); err != nil {
+		t.Fatalf("unable to read gauge: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestExtractFromPathRecordsMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-metrics")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	source := newTestSourceFile(dir, updates)
+
+	scansBefore := counterValue(t, fileSourceScansTotal)
+	writeFile(t, filepath.Join(dir, "pod1.json"), manifestJSON("pod1"))
+	if err := source.extractFromPath(); err != nil {
+		t.Fatalf("extractFromPath: %v", err)
+	}
+	if got := counterValue(t, fileSourceScansTotal); got != scansBefore+1 {
+		t.Fatalf("expected fileSourceScansTotal to increment by 1, got %v -> %v", scansBefore, got)
+	}
+	if got := gaugeValue(t, dir); got != 1 {
+		t.Fatalf("expected fileSourceLastPodCount(%q) to be 1, got %v", dir, got)
+	}
+
+	// extractFromDir never fails the whole scan over one bad file, so drive
+	// the "other" reason bucket through single-file mode instead, where a
+	// fully-invalid file's validation error does propagate.
+	badPath := filepath.Join(dir, "bad.json")
+	writeFile(t, badPath, invalidManifestJSON("bad"))
+	badSource := newTestSourceFile(badPath, updates)
+	errorsBefore := counterValue(t, fileSourceScanErrorsTotal.WithLabelValues("other"))
+	if err := badSource.extractFromPath(); err == nil {
+		t.Fatalf("expected an error from a file that fails validation")
+	}
+	if got := counterValue(t, fileSourceScanErrorsTotal.WithLabelValues("other")); got != errorsBefore+1 {
+		t.Fatalf("expected fileSourceScanErrorsTotal{reason=other} to increment by 1, got %v -> %v", errorsBefore, got)
+	}
+}
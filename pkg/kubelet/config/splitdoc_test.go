@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestSplitDocumentsSingleDocument(t *testing.T) {
+	data := []byte(`{"id": "single"}`)
+	docs, err := splitDocuments(data)
+	if err != nil {
+		t.Fatalf("splitDocuments: %v", err)
+	}
+	if len(docs) != 1 || string(docs[0]) != `{"id": "single"}` {
+		t.Fatalf("expected a single unmodified document, got %v", docs)
+	}
+}
+
+func TestSplitDocumentsYAMLSeparator(t *testing.T) {
+	data := []byte("{\"id\": \"a\"}\n---\n{\"id\": \"b\"}\n---\n{\"id\": \"c\"}\n")
+	docs, err := splitDocuments(data)
+	if err != nil {
+		t.Fatalf("splitDocuments: %v", err)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents, got %d: %v", len(docs), docs)
+	}
+	for i, want := range []string{`{"id": "a"}`, `{"id": "b"}`, `{"id": "c"}`} {
+		if string(docs[i]) != want {
+			t.Errorf("doc %d: got %q, want %q", i, docs[i], want)
+		}
+	}
+}
+
+func TestSplitDocumentsJSONArray(t *testing.T) {
+	data := []byte(`[{"id": "a"}, {"id": "b"}]`)
+	docs, err := splitDocuments(data)
+	if err != nil {
+		t.Fatalf("splitDocuments: %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("expected 2 documents from a JSON array, got %d: %v", len(docs), docs)
+	}
+}
+
+func TestSplitDocumentsPodList(t *testing.T) {
+	data := []byte(`{"kind": "PodList", "items": [{"id": "a"}, {"id": "b"}, {"id": "c"}]}`)
+	docs, err := splitDocuments(data)
+	if err != nil {
+		t.Fatalf("splitDocuments: %v", err)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents from a PodList, got %d: %v", len(docs), docs)
+	}
+}
+
+func TestSplitDocumentsInvalidJSONArray(t *testing.T) {
+	data := []byte(`[{"id": "a"`)
+	if _, err := splitDocuments(data); err == nil {
+		t.Fatalf("expected an error for a malformed JSON array")
+	}
+}
+
+func TestDocPodMapSingleDocumentUsesBarePath(t *testing.T) {
+	pods := docPodMap("/etc/kubernetes/manifests/pod.json", []api.Pod{{}})
+	if _, ok := pods["/etc/kubernetes/manifests/pod.json"]; !ok {
+		t.Fatalf("expected the bare path as key for a single-document file, got %v", keys(pods))
+	}
+}
+
+func TestDocPodMapMultiDocumentUsesIndexedKeys(t *testing.T) {
+	pods := docPodMap("/etc/kubernetes/manifests/bundle.json", []api.Pod{{}, {}})
+	want := map[string]bool{
+		"/etc/kubernetes/manifests/bundle.json[0]": true,
+		"/etc/kubernetes/manifests/bundle.json[1]": true,
+	}
+	if !reflect.DeepEqual(keySet(pods), want) {
+		t.Fatalf("got keys %v, want %v", keys(pods), want)
+	}
+}
+
+func keys(pods map[string]api.Pod) []string {
+	ks := make([]string, 0, len(pods))
+	for k := range pods {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func keySet(pods map[string]api.Pod) map[string]bool {
+	ks := make(map[string]bool, len(pods))
+	for k := range pods {
+		ks[k] = true
+	}
+	return ks
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Reads the pod configuration from an HTTP(S) URL.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubelet"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+
+	"github.com/golang/glog"
+)
+
+const (
+	urlMinBackoff = 1 * time.Second
+	urlMaxBackoff = 30 * time.Second
+)
+
+type sourceURL struct {
+	url     string
+	header  http.Header
+	client  *http.Client
+	updates chan<- interface{}
+
+	// etag and lastModified are the validators returned with the last
+	// successful, non-304 response; sending them back lets the server tell
+	// us nothing has changed instead of us re-downloading and re-parsing.
+	etag         string
+	lastModified string
+}
+
+// NewSourceURL starts polling url for a pod, manifest, or pod list every
+// period, sending PodUpdates on updates.  header is sent with every request,
+// so a caller needing bearer-token auth can set an Authorization header
+// here; for mTLS or other transport-level auth, use NewSourceURLWithClient.
+func NewSourceURL(url string, header http.Header, period time.Duration, updates chan<- interface{}) {
+	NewSourceURLWithClient(url, header, period, &http.Client{Timeout: 10 * time.Second}, updates)
+}
+
+// NewSourceURLWithClient is like NewSourceURL but allows a caller to supply
+// an http.Client configured for mTLS or other transport-level auth.
+func NewSourceURLWithClient(url string, header http.Header, period time.Duration, client *http.Client, updates chan<- interface{}) {
+	config := &sourceURL{
+		url:     url,
+		header:  header,
+		client:  client,
+		updates: updates,
+	}
+	glog.V(1).Infof("Watching URL %s", url)
+	go util.Forever(config.run, period)
+}
+
+func (s *sourceURL) run() {
+	if err := s.extractFromURL(); err != nil {
+		glog.Errorf("Unable to read config from URL %q: %v", s.url, err)
+	}
+}
+
+func (s *sourceURL) extractFromURL() error {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range s.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		glog.V(4).Infof("Config at %q has not changed since last poll", s.url)
+		return nil
+
+	case resp.StatusCode >= 500:
+		backoff := jitteredBackoff()
+		glog.Errorf("Server error (%s) fetching config from %q, backing off %v", resp.Status, s.url, backoff)
+		time.Sleep(backoff)
+		return fmt.Errorf("server error fetching %q: %s", s.url, resp.Status)
+
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected response fetching %q: %s", s.url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	pods, err := extractFromURLResponse(data, s.url)
+	if err != nil {
+		return err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+
+	s.updates <- kubelet.PodUpdate{pods, kubelet.SET, kubelet.URLSource}
+	return nil
+}
+
+// extractFromURLResponse parses the body of a URL source response, which may
+// hold a single pod/manifest or, via splitDocuments, a JSON array/PodList of
+// several.
+func extractFromURLResponse(data []byte, url string) ([]api.Pod, error) {
+	docs, err := splitDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]api.Pod, 0, len(docs))
+	for i, doc := range docs {
+		name := url
+		if len(docs) > 1 {
+			name = fmt.Sprintf("%s[%d]", url, i)
+		}
+		pod, err := decodeDocument(doc, name)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// backoffRand is seeded per-process rather than using the math/rand global
+// source, which defaults to a fixed seed: an unseeded source would make
+// every kubelet compute the same backoff sequence, defeating the jitter.
+// rand.Rand isn't safe for concurrent use, hence the mutex.
+var (
+	backoffRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	backoffRandMu sync.Mutex
+)
+
+// jitteredBackoff returns a random duration in [urlMinBackoff, urlMaxBackoff)
+// so that many kubelets hitting the same flaky config server don't retry in
+// lockstep.
+func jitteredBackoff() time.Duration {
+	backoffRandMu.Lock()
+	defer backoffRandMu.Unlock()
+	return urlMinBackoff + time.Duration(backoffRand.Int63n(int64(urlMaxBackoff-urlMinBackoff)))
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubelet"
+)
+
+func TestExtractFromURLSuccessSetsETagAndEmitsUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(manifestJSON("from-url"))
+	}))
+	defer server.Close()
+
+	updates := make(chan interface{}, 10)
+	source := &sourceURL{url: server.URL, client: &http.Client{}, updates: updates}
+
+	if err := source.extractFromURL(); err != nil {
+		t.Fatalf("extractFromURL: %v", err)
+	}
+	select {
+	case got := <-updates:
+		update, ok := got.(kubelet.PodUpdate)
+		if !ok || update.Op != kubelet.SET || update.Source != kubelet.URLSource {
+			t.Fatalf("unexpected update: %#v", got)
+		}
+	default:
+		t.Fatalf("expected a PodUpdate")
+	}
+	if source.etag != `"v1"` {
+		t.Fatalf("expected etag to be recorded, got %q", source.etag)
+	}
+}
+
+func TestExtractFromURLNotModifiedSkipsUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(manifestJSON("from-url"))
+	}))
+	defer server.Close()
+
+	updates := make(chan interface{}, 10)
+	source := &sourceURL{url: server.URL, client: &http.Client{}, updates: updates}
+
+	if err := source.extractFromURL(); err != nil {
+		t.Fatalf("extractFromURL: %v", err)
+	}
+	<-updates // drain the initial update
+
+	if err := source.extractFromURL(); err != nil {
+		t.Fatalf("extractFromURL on 304: %v", err)
+	}
+	select {
+	case got := <-updates:
+		t.Fatalf("expected no update on a 304 response, got %#v", got)
+	default:
+	}
+}
+
+func TestExtractFromURLServerErrorBacksOff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	updates := make(chan interface{}, 10)
+	source := &sourceURL{url: server.URL, client: &http.Client{}, updates: updates}
+
+	start := time.Now()
+	err := source.extractFromURL()
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatalf("expected an error for a 5xx response")
+	}
+	if elapsed < urlMinBackoff {
+		t.Fatalf("expected extractFromURL to back off for at least %v, took %v", urlMinBackoff, elapsed)
+	}
+	select {
+	case got := <-updates:
+		t.Fatalf("expected no update on a server error, got %#v", got)
+	default:
+	}
+}
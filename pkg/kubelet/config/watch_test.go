@@ -0,0 +1,84 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubelet"
+)
+
+func TestNewWatcherWatchesParentDirOfRegularFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-watcher")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pod.json")
+	writeFile(t, path, manifestJSON("a"))
+
+	source := &sourceFile{path: path, log: glogLogger{}}
+	watcher, err := source.newWatcher()
+	if err != nil {
+		t.Fatalf("newWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	writeFile(t, path, manifestJSON("b"))
+
+	select {
+	case event := <-watcher.Event:
+		if event == nil {
+			t.Fatalf("expected a filesystem event")
+		}
+	case err := <-watcher.Error:
+		t.Fatalf("unexpected watcher error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a watch event on the file's parent directory")
+	}
+}
+
+func TestRunForeverEmitsUpdateOnWatchedDirChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-source-runforever")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan interface{}, 10)
+	// A long period means no periodic re-scan happens within the test's
+	// timeout, so an update seen here comes from the watcher or, if it runs
+	// after the write below, from the initial scan.
+	NewSourceFile(dir, time.Hour, updates)
+
+	writeFile(t, filepath.Join(dir, "pod.json"), manifestJSON("watched"))
+
+	select {
+	case got := <-updates:
+		update, ok := got.(kubelet.PodUpdate)
+		if !ok || update.Op != kubelet.ADD {
+			t.Fatalf("expected an ADD update from the watcher, got %#v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a watcher-triggered update")
+	}
+}